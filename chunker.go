@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+const (
+	chunkMinSize     = 512 * 1024      // 512KiB
+	chunkAverageSize = 1024 * 1024     // ~1MiB, matched by chunkMask below
+	chunkMaxSize     = 8 * 1024 * 1024 // 8MiB
+	chunkWindowSize  = 64
+	chunkMask        = chunkAverageSize - chunkMinSize - 1
+	chunkPolynomial  = 0x3DA3358B4DC173 // arbitrary odd 54-bit constant, used as the rolling hash base
+)
+
+// chunk is one content-defined slice of a file, identified by the SHA-256
+// of its bytes so identical chunks across backup runs are only ever stored
+// once.
+type chunk struct {
+	id   string
+	data []byte
+}
+
+// chunkReader splits r into content-defined chunks using a rolling-hash
+// (Rabin-fingerprint-style) boundary rule: a boundary falls wherever the low
+// bits of the hash of the trailing chunkWindowSize bytes are all zero,
+// bounded by chunkMinSize/chunkMaxSize so that pathological input (e.g. a
+// long run of zeroes) can't produce degenerate chunk sizes. The hash is kept
+// over a true fixed-size sliding window (each incoming byte's contribution
+// is added and the byte that falls off the trailing edge is subtracted back
+// out), so it resyncs within chunkWindowSize bytes of any edit rather than
+// carrying a perturbation all the way to the next boundary. Because the
+// boundary is a function of local content rather than a fixed offset, an
+// insertion or deletion in the middle of a file only changes the chunks
+// immediately around it, which is what makes subsequent neo4j backups of a
+// mostly-unchanged graph.db dramatically cheaper to store.
+//
+// Chunks are handed to onChunk as soon as a boundary is found, rather than
+// accumulated and returned once r is exhausted, so a caller streaming a
+// multi-GB graph.db store file never has to hold more than one chunk (at
+// most chunkMaxSize) in memory at a time.
+func chunkReader(r io.Reader, onChunk func(chunk) error) error {
+	buf := make([]byte, 0, chunkMaxSize)
+	var window uint64
+	var ring [chunkWindowSize]byte
+	var ringPos int
+	var ringFilled int
+	// chunkWindowLeadCoefficient is chunkPolynomial^(chunkWindowSize-1): the
+	// weight the oldest byte in the window carries, needed to subtract its
+	// contribution back out as it slides off the trailing edge.
+	var chunkWindowLeadCoefficient uint64 = 1
+	for i := 0; i < chunkWindowSize-1; i++ {
+		chunkWindowLeadCoefficient *= chunkPolynomial
+	}
+	readBuf := make([]byte, 32*1024)
+
+	cut := func() error {
+		sum := sha256.Sum256(buf)
+		data := make([]byte, len(buf))
+		copy(data, buf)
+		buf = buf[:0]
+		window = 0
+		ringPos = 0
+		ringFilled = 0
+		return onChunk(chunk{id: hex.EncodeToString(sum[:]), data: data})
+	}
+
+	for {
+		n, err := r.Read(readBuf)
+		for i := 0; i < n; i++ {
+			b := readBuf[i]
+			buf = append(buf, b)
+
+			if ringFilled == chunkWindowSize {
+				outgoing := ring[ringPos]
+				window -= uint64(outgoing) * chunkWindowLeadCoefficient
+			} else {
+				ringFilled++
+			}
+			window = window*chunkPolynomial + uint64(b)
+			ring[ringPos] = b
+			ringPos = (ringPos + 1) % chunkWindowSize
+
+			if len(buf) >= chunkMinSize && ringFilled == chunkWindowSize && window&chunkMask == 0 {
+				if cutErr := cut(); cutErr != nil {
+					return cutErr
+				}
+				continue
+			}
+			if len(buf) >= chunkMaxSize {
+				if cutErr := cut(); cutErr != nil {
+					return cutErr
+				}
+			}
+		}
+		if err == io.EOF {
+			if len(buf) > 0 {
+				return cut()
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
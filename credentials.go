@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// CredentialsRef lets awsAccessKey/awsSecretKey (and any orchestrator
+// credentials) be sourced from a secret store instead of being passed as
+// literal values in env vars or on the command line, where they would end
+// up in systemd unit files or shell history. A zero-value CredentialsRef
+// resolves to the literal key/secret passed alongside it.
+type CredentialsRef struct {
+	// Ref is one of: "" (use the literal key/secret), "iam" (EC2 instance
+	// role via IMDSv2), "vault://<path>#<field>", "k8s:///<path-to-secret-dir>",
+	// or "secret://<name>", read from a Kubernetes Secret mounted at
+	// /var/run/secrets/<name>.
+	Ref string
+}
+
+// resolveCredentials turns a literal key/secret pair plus an optional
+// CredentialsRef into the access key/secret actually used to talk to S3.
+// It is called from newStorageBackend so the rest of the pipeline never has
+// to know where credentials came from.
+func resolveCredentials(literalAccessKey string, literalSecretKey string, ref CredentialsRef) (string, string, error) {
+	switch {
+	case ref.Ref == "":
+		return literalAccessKey, literalSecretKey, nil
+	case ref.Ref == "iam":
+		return resolveIAMCredentials()
+	case strings.HasPrefix(ref.Ref, "vault://"):
+		return resolveVaultCredentials(ref.Ref)
+	case strings.HasPrefix(ref.Ref, "k8s://"):
+		return resolveK8sSecretCredentials(strings.TrimPrefix(ref.Ref, "k8s://"))
+	case strings.HasPrefix(ref.Ref, "secret://"):
+		return resolveNamedSecretCredentials(strings.TrimPrefix(ref.Ref, "secret://"))
+	default:
+		return "", "", fmt.Errorf("unrecognised credentials ref %q", ref.Ref)
+	}
+}
+
+// resolveIAMCredentials sources temporary credentials from the EC2
+// instance's attached IAM role via IMDSv2, rather than storing a long-lived
+// access key and secret key anywhere.
+func resolveIAMCredentials() (string, string, error) {
+	creds := credentials.NewCredentials(&ec2rolecreds.EC2RoleProvider{
+		Client: ec2metadata.New(session.Must(session.NewSession())),
+	})
+	value, err := creds.Get()
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Error retrieving IAM role credentials via IMDSv2.")
+		return "", "", err
+	}
+	return value.AccessKeyID, value.SecretAccessKey, nil
+}
+
+// resolveVaultCredentials reads an access key/secret key pair from
+// HashiCorp Vault. The ref is of the form "vault://secret/data/s3#field",
+// where "field" (the URL fragment) names a JSON object with accessKey/
+// secretKey keys, defaulting to a JSON object with those keys at the top
+// level of the secret if no fragment is given. A KV v2 mount (implied by a
+// "/data/" path segment, as in the example above) nests the secret's actual
+// fields one level down under "data", which is unwrapped automatically.
+func resolveVaultCredentials(ref string) (string, string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", "", err
+	}
+	client, err := vaultapi.NewClient(vaultapi.DefaultConfig())
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Error creating Vault client.")
+		return "", "", err
+	}
+	path := strings.TrimPrefix(u.Host+u.Path, "/")
+	secret, err := client.Logical().Read(path)
+	if err != nil {
+		log.WithFields(log.Fields{"path": path, "err": err}).Error("Error reading secret from Vault.")
+		return "", "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", "", fmt.Errorf("no secret found in Vault at %q", path)
+	}
+
+	fields := secret.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		fields = nested
+	}
+	if u.Fragment != "" {
+		nested, ok := fields[u.Fragment].(map[string]interface{})
+		if !ok {
+			return "", "", fmt.Errorf("field %q not found (or not an object) in Vault secret at %q", u.Fragment, path)
+		}
+		fields = nested
+	}
+
+	accessKey, ok := fields["accessKey"].(string)
+	if !ok {
+		return "", "", fmt.Errorf("no accessKey field found in Vault secret at %q", path)
+	}
+	secretKey, ok := fields["secretKey"].(string)
+	if !ok {
+		return "", "", fmt.Errorf("no secretKey field found in Vault secret at %q", path)
+	}
+	return accessKey, secretKey, nil
+}
+
+// resolveK8sSecretCredentials reads an access key/secret key pair from a
+// Kubernetes Secret mounted at a path, e.g. as files "accessKey" and
+// "secretKey" under /var/run/secrets/s3-credentials.
+func resolveK8sSecretCredentials(mountPath string) (string, string, error) {
+	accessKey, err := ioutil.ReadFile(filepath.Join(mountPath, "accessKey"))
+	if err != nil {
+		log.WithFields(log.Fields{"mountPath": mountPath, "err": err}).Error("Error reading mounted Kubernetes Secret.")
+		return "", "", err
+	}
+	secretKey, err := ioutil.ReadFile(filepath.Join(mountPath, "secretKey"))
+	if err != nil {
+		log.WithFields(log.Fields{"mountPath": mountPath, "err": err}).Error("Error reading mounted Kubernetes Secret.")
+		return "", "", err
+	}
+	return strings.TrimSpace(string(accessKey)), strings.TrimSpace(string(secretKey)), nil
+}
+
+// resolveNamedSecretCredentials resolves a "secret://<name>" reference via
+// whatever secret store is configured as the default for the environment;
+// this currently falls through to the Kubernetes Secret resolution, mounted
+// under a well-known root, since that's the deployment target most callers
+// of secret:// URLs run on.
+func resolveNamedSecretCredentials(name string) (string, string, error) {
+	return resolveK8sSecretCredentials(filepath.Join("/var/run/secrets", name))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptionKeySize         = 32 // AES-256
+	encryptionSaltSize        = 16
+	encryptionNonceSize       = 12
+	encryptionRecordSize      = 32 * 1024 // max plaintext bytes sealed per record
+	encryptionLengthPrefixLen = 4         // uint32 big-endian length of nonce||ciphertext||tag
+)
+
+// encryptingWriter wraps an io.WriteCloser (the bucketWriter) with AES-256-GCM
+// client-side encryption, inserted as a stream transform between
+// createBackup's PipeReader and the bucketWriter so the whole archive never
+// needs to be buffered. The random salt used to derive the key from the
+// passphrase is written as a header so decryptingReader can recover it.
+//
+// Write splits its input into independently-sealed records of at most
+// encryptionRecordSize plaintext bytes each, framed with a length prefix, so
+// the framing never has to assume anything about the size of a caller's
+// Write call: both the tar/gzip archive path (which writes via io.Copy's
+// 32KiB buffer) and the incremental backup path (which seals an entire pack,
+// batched up to packTargetSize, in a single Write) decrypt correctly.
+type encryptingWriter struct {
+	dest   io.WriteCloser
+	aesgcm cipher.AEAD
+	header bool
+	salt   [encryptionSaltSize]byte
+}
+
+func newEncryptingWriter(dest io.WriteCloser, passphrase string) (*encryptingWriter, error) {
+	if passphrase == "" {
+		return nil, errors.New("encryption passphrase must not be empty")
+	}
+	var salt [encryptionSaltSize]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return nil, err
+	}
+	aesgcm, err := newAESGCM(passphrase, salt[:])
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{dest: dest, aesgcm: aesgcm, salt: salt}, nil
+}
+
+// Write splits p into records of at most encryptionRecordSize plaintext
+// bytes, and for each writes a 4-byte big-endian length prefix followed by
+// nonce||ciphertext||tag, prefixed once overall by the salt header on first
+// write.
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	if !w.header {
+		if _, err := w.dest.Write(w.salt[:]); err != nil {
+			return 0, err
+		}
+		w.header = true
+	}
+	total := len(p)
+	for len(p) > 0 {
+		n := len(p)
+		if n > encryptionRecordSize {
+			n = encryptionRecordSize
+		}
+		record := p[:n]
+		p = p[n:]
+
+		nonce := make([]byte, encryptionNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return 0, err
+		}
+		sealed := w.aesgcm.Seal(nonce, nonce, record, nil)
+
+		var lengthPrefix [encryptionLengthPrefixLen]byte
+		binary.BigEndian.PutUint32(lengthPrefix[:], uint32(len(sealed)))
+		if _, err := w.dest.Write(lengthPrefix[:]); err != nil {
+			return 0, err
+		}
+		if _, err := w.dest.Write(sealed); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+func (w *encryptingWriter) Close() error {
+	return w.dest.Close()
+}
+
+// decryptingReader reverses encryptingWriter's framing for the restore/decrypt
+// subcommand: it reads the salt header once, then each length-prefixed
+// nonce||ciphertext||tag record in turn, regardless of how large the record
+// or how it was originally batched by the writer.
+type decryptingReader struct {
+	src        io.Reader
+	aesgcm     cipher.AEAD
+	passphrase string
+	header     bool
+	buf        []byte
+}
+
+func newDecryptingReader(src io.Reader, passphrase string) *decryptingReader {
+	return &decryptingReader{src: src, passphrase: passphrase}
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) fill() error {
+	if !r.header {
+		salt := make([]byte, encryptionSaltSize)
+		if _, err := io.ReadFull(r.src, salt); err != nil {
+			return err
+		}
+		aesgcm, err := newAESGCM(r.passphrase, salt)
+		if err != nil {
+			return err
+		}
+		r.aesgcm = aesgcm
+		r.header = true
+	}
+	var lengthPrefix [encryptionLengthPrefixLen]byte
+	if _, err := io.ReadFull(r.src, lengthPrefix[:]); err != nil {
+		return err
+	}
+	sealed := make([]byte, binary.BigEndian.Uint32(lengthPrefix[:]))
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		return err
+	}
+	if len(sealed) < encryptionNonceSize {
+		return errors.New("encrypted record shorter than a nonce; stream is corrupt")
+	}
+	nonce, ciphertext := sealed[:encryptionNonceSize], sealed[encryptionNonceSize:]
+	plain, err := r.aesgcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return err
+	}
+	r.buf = plain
+	return nil
+}
+
+func newAESGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, encryptionKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sseOptions captures the server-side encryption settings to apply when
+// requesting a bucket writer, passed through to the S3 backend's PutObject
+// call. SSE-S3 is used when kmsKeyID is empty but enabled is true; SSE-KMS is
+// used when kmsKeyID is set.
+type sseOptions struct {
+	enabled bool
+	kmsKeyID string
+}
+
+// wrapWithClientEncryption inserts client-side AES-256-GCM encryption
+// between createBackup's PipeReader and the bucketWriter when a passphrase
+// is configured, otherwise it returns the writer unchanged.
+func wrapWithClientEncryption(bucketWriter io.WriteCloser, passphrase string) (io.WriteCloser, error) {
+	if passphrase == "" {
+		return bucketWriter, nil
+	}
+	log.Info("Client-side encryption enabled; wrapping bucket writer with AES-256-GCM stream transform.")
+	return newEncryptingWriter(bucketWriter, passphrase)
+}
+
+// wrapWithClientDecryption is wrapWithClientEncryption's read-side
+// counterpart: it returns r unchanged when no passphrase is configured,
+// otherwise a decryptingReader reversing encryptingWriter's framing.
+func wrapWithClientDecryption(r io.Reader, passphrase string) io.Reader {
+	if passphrase == "" {
+		return r
+	}
+	return newDecryptingReader(r, passphrase)
+}
+
+// runRestore reverses the backup pipeline: it reads an (optionally
+// encrypted) archive from the storage backend and decrypts it to
+// targetPath, for operators restoring a neo4j backup taken with
+// --encryptionPassphrase set.
+func runRestore(cfg config, archiveName string, targetPath string) error {
+	storageBackend, err := newStorageBackend(cfg.backend, cfg)
+	if err != nil {
+		log.WithFields(log.Fields{"backend": cfg.backend, "err": err}).Error("Error instantiating storage backend; restore failed.")
+		return err
+	}
+	reader, err := storageBackend.GetReader(archiveName)
+	if err != nil {
+		log.WithFields(log.Fields{"archiveName": archiveName, "err": err}).Error("Error reading archive from storage backend; restore failed.")
+		return err
+	}
+	defer reader.Close()
+
+	plainReader := wrapWithClientDecryption(reader, cfg.encryptionPassphrase)
+
+	out, err := os.Create(targetPath)
+	if err != nil {
+		log.WithFields(log.Fields{"targetPath": targetPath, "err": err}).Error("Error creating restore target file; restore failed.")
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, plainReader); err != nil {
+		log.WithFields(log.Fields{"archiveName": archiveName, "targetPath": targetPath, "err": err}).Error("Error decrypting archive; restore failed.")
+		return err
+	}
+	log.WithFields(log.Fields{"archiveName": archiveName, "targetPath": targetPath}).Info("Archive restored and decrypted successfully.")
+	return nil
+}
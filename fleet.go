@@ -52,9 +52,12 @@ func newFleetClient(fleetEndpoint string, socksProxy string) (fleetAPI, error) {
 	return fleetHTTPAPIClient, err
 }
 
-func shutDownNeo(fleetClient fleetAPI) (error) {
+const neoServiceName = "neo4j-red@1.service"
+const neoStateChangeTimeout = 2 * time.Minute
+
+func shutDownNeo(orchestrator Orchestrator) (error) {
 	deployerServiceName := "deployer.service"
-	isDeployerActive, err := isServiceActive(fleetClient, deployerServiceName)
+	isDeployerActive, err := orchestrator.IsActive(deployerServiceName)
 	if isDeployerActive || err != nil {
 		log.WithFields(log.Fields{
 			"deployerServiceName": deployerServiceName,
@@ -70,11 +73,11 @@ We cannot complete the backup process in case neo4j is accidentally started up a
 		}
 
 	}
-	// TODO use the Go fleet API to shut down neo4j's dependencies (ingesters?).
-	serviceName := "neo4j-red@1.service"
-	err = setTargetState(fleetClient, serviceName, "inactive")
-	return err
-	// TODO check whether neo4j has successfully been shut down
+	// TODO use the orchestrator to shut down neo4j's dependencies (ingesters?).
+	if err := orchestrator.Stop(neoServiceName); err != nil {
+		return err
+	}
+	return orchestrator.WaitFor(neoServiceName, "inactive", neoStateChangeTimeout)
 }
 
 func setTargetState(fleetClient fleetAPI, serviceName string, targetState string) (error) {
@@ -126,11 +129,37 @@ func isServiceActive(fleetClient fleetAPI, serviceName string) (bool, error) {
 	return isActive, err
 }
 
-func startNeo(fleetClient fleetAPI) (error) {
+func startNeo(orchestrator Orchestrator) (error) {
 	log.Info("Starting up neo4j...")
-	serviceName := "neo4j-red@1.service"
-	setTargetState(fleetClient, serviceName, "launched")
-	// TODO confirm that neo4j has successfully started up.
-	return nil
+	if err := orchestrator.Start(neoServiceName); err != nil {
+		return err
+	}
+	return orchestrator.WaitFor(neoServiceName, "active", neoStateChangeTimeout)
+}
+
+// fleetOrchestrator adapts the raw fleetAPI client to the Orchestrator
+// interface, preserving the tool's original behaviour.
+type fleetOrchestrator struct {
+	fleetClient fleetAPI
+}
+
+func newFleetOrchestrator(fleetClient fleetAPI) *fleetOrchestrator {
+	return &fleetOrchestrator{fleetClient: fleetClient}
+}
+
+func (o *fleetOrchestrator) Stop(serviceName string) error {
+	return setTargetState(o.fleetClient, serviceName, "inactive")
+}
+
+func (o *fleetOrchestrator) Start(serviceName string) error {
+	return setTargetState(o.fleetClient, serviceName, "launched")
+}
+
+func (o *fleetOrchestrator) IsActive(serviceName string) (bool, error) {
+	return isServiceActive(o.fleetClient, serviceName)
+}
+
+func (o *fleetOrchestrator) WaitFor(serviceName string, state string, timeout time.Duration) error {
+	return pollUntilState(o, serviceName, state, timeout)
 }
 
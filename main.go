@@ -24,6 +24,38 @@ type config struct {
 	s3Domain string
 	bucketName string
 	env string
+	backend string
+	gcsBucket string
+	gcsCredentialsFile string
+	azureAccount string
+	azureKey string
+	azureContainer string
+	localBackupDir string
+	sftpAddr string
+	sftpUser string
+	sftpKeyFile string
+	sftpRemoteDir string
+	sftpKnownHostsFile string
+	schedule string
+	retentionDays int
+	retentionCount int
+	adminAddr string
+	sseEnabled bool
+	kmsKeyID string
+	encryptionPassphrase string
+	notifyURL string
+	metricsAddr string
+	pushgatewayURL string
+	orchestrator string
+	k8sNamespace string
+	k8sStatefulSet string
+	k8sKubeconfig string
+	sshAddr string
+	sshUser string
+	sshKeyFile string
+	sshKnownHostsFile string
+	credentialsRef string
+	archiveStrategy string
 }
 
 func main() {
@@ -88,9 +120,200 @@ func main() {
 			Usage: "connect to CoCo environment with tag `ENVIRONMENT_TAG`",
 			EnvVar: "ENVIRONMENT_TAG",
 		},
+		cli.StringFlag{
+			Name: "backend",
+			Value: "s3",
+			Usage: "upload archive using storage backend `BACKEND` (one of: s3, gcs, azure, local, sftp)",
+			EnvVar: "BACKUP_BACKEND",
+		},
+		cli.StringFlag{
+			Name: "gcsBucket",
+			Value: "",
+			Usage: "(gcs backend) upload archive to Google Cloud Storage bucket `GCS_BUCKET`",
+			EnvVar: "GCS_BUCKET",
+		},
+		cli.StringFlag{
+			Name: "gcsCredentialsFile",
+			Value: "",
+			Usage: "(gcs backend) authenticate using service account credentials file at `GCS_CREDENTIALS_FILE`",
+			EnvVar: "GCS_CREDENTIALS_FILE",
+		},
+		cli.StringFlag{
+			Name: "azureAccount",
+			Value: "",
+			Usage: "(azure backend) upload archive using Azure storage account `AZURE_ACCOUNT`",
+			EnvVar: "AZURE_ACCOUNT",
+		},
+		cli.StringFlag{
+			Name: "azureKey",
+			Value: "",
+			Usage: "(azure backend) authenticate with Azure storage account using shared key `AZURE_KEY`",
+			EnvVar: "AZURE_KEY",
+		},
+		cli.StringFlag{
+			Name: "azureContainer",
+			Value: "",
+			Usage: "(azure backend) upload archive to Azure Blob container `AZURE_CONTAINER`",
+			EnvVar: "AZURE_CONTAINER",
+		},
+		cli.StringFlag{
+			Name: "localBackupDir",
+			Value: "/data/backups",
+			Usage: "(local backend) copy archive to local directory `LOCAL_BACKUP_DIR`",
+			EnvVar: "LOCAL_BACKUP_DIR",
+		},
+		cli.StringFlag{
+			Name: "sftpAddr",
+			Value: "",
+			Usage: "(sftp backend) upload archive to SFTP host at `SFTP_ADDR` in IP:PORT format",
+			EnvVar: "SFTP_ADDR",
+		},
+		cli.StringFlag{
+			Name: "sftpUser",
+			Value: "",
+			Usage: "(sftp backend) authenticate to SFTP host as `SFTP_USER`",
+			EnvVar: "SFTP_USER",
+		},
+		cli.StringFlag{
+			Name: "sftpKeyFile",
+			Value: "",
+			Usage: "(sftp backend) authenticate to SFTP host using private key file at `SFTP_KEY_FILE`",
+			EnvVar: "SFTP_KEY_FILE",
+		},
+		cli.StringFlag{
+			Name: "sftpRemoteDir",
+			Value: "",
+			Usage: "(sftp backend) upload archive to remote directory `SFTP_REMOTE_DIR`",
+			EnvVar: "SFTP_REMOTE_DIR",
+		},
+		cli.StringFlag{
+			Name: "sftpKnownHostsFile",
+			Value: "",
+			Usage: "(sftp backend) verify the SFTP host key against known_hosts file at `SFTP_KNOWN_HOSTS_FILE`",
+			EnvVar: "SFTP_KNOWN_HOSTS_FILE",
+		},
+		cli.StringFlag{
+			Name: "schedule",
+			Value: "",
+			Usage: "run as a daemon, producing backups on cron expression `SCHEDULE` instead of running once",
+			EnvVar: "BACKUP_SCHEDULE",
+		},
+		cli.IntFlag{
+			Name: "retentionDays",
+			Value: 0,
+			Usage: "(daemon mode) prune archives older than `RETENTION_DAYS` days from the bucket (0 disables)",
+			EnvVar: "RETENTION_DAYS",
+		},
+		cli.IntFlag{
+			Name: "retentionCount",
+			Value: 0,
+			Usage: "(daemon mode) keep only the `RETENTION_COUNT` most recent archives in the bucket (0 disables)",
+			EnvVar: "RETENTION_COUNT",
+		},
+		cli.StringFlag{
+			Name: "adminAddr",
+			Value: ":8081",
+			Usage: "(daemon mode) serve the admin HTTP endpoint on `ADMIN_ADDR`",
+			EnvVar: "ADMIN_ADDR",
+		},
+		cli.BoolFlag{
+			Name: "sse",
+			Usage: "(s3 backend) enable SSE-S3 server-side encryption of the uploaded archive",
+			EnvVar: "SSE_ENABLED",
+		},
+		cli.StringFlag{
+			Name: "kmsKeyId",
+			Value: "",
+			Usage: "(s3 backend) enable SSE-KMS server-side encryption using KMS key `KMS_KEY_ID`",
+			EnvVar: "KMS_KEY_ID",
+		},
+		cli.StringFlag{
+			Name: "encryptionPassphrase",
+			Value: "",
+			Usage: "encrypt the archive client-side with AES-256-GCM using `ENCRYPTION_PASSPHRASE` before upload",
+			EnvVar: "ENCRYPTION_PASSPHRASE",
+		},
+		cli.StringFlag{
+			Name: "notify-url",
+			Value: "",
+			Usage: "send a templated success/failure report to `NOTIFY_URL` (shoutrrr service URL, e.g. slack://...)",
+			EnvVar: "NOTIFY_URL",
+		},
+		cli.StringFlag{
+			Name: "metrics-addr",
+			Value: "",
+			Usage: "serve Prometheus metrics on `METRICS_ADDR` (empty disables the endpoint)",
+			EnvVar: "METRICS_ADDR",
+		},
+		cli.StringFlag{
+			Name: "pushgateway",
+			Value: "",
+			Usage: "push Prometheus metrics to Pushgateway at `PUSHGATEWAY_URL` after each run",
+			EnvVar: "PUSHGATEWAY_URL",
+		},
+		cli.StringFlag{
+			Name: "orchestrator",
+			Value: "fleet",
+			Usage: "stop/start neo4j using orchestrator `ORCHESTRATOR` (one of: fleet, k8s, systemd, ssh)",
+			EnvVar: "ORCHESTRATOR",
+		},
+		cli.StringFlag{
+			Name: "k8sNamespace",
+			Value: "default",
+			Usage: "(k8s orchestrator) namespace containing the neo4j `K8S_NAMESPACE`",
+			EnvVar: "K8S_NAMESPACE",
+		},
+		cli.StringFlag{
+			Name: "k8sStatefulSet",
+			Value: "neo4j",
+			Usage: "(k8s orchestrator) name of the neo4j `K8S_STATEFULSET`",
+			EnvVar: "K8S_STATEFULSET",
+		},
+		cli.StringFlag{
+			Name: "k8sKubeconfig",
+			Value: "",
+			Usage: "(k8s orchestrator) path to kubeconfig file at `K8S_KUBECONFIG` (empty uses in-cluster config)",
+			EnvVar: "K8S_KUBECONFIG",
+		},
+		cli.StringFlag{
+			Name: "sshAddr",
+			Value: "",
+			Usage: "(systemd/ssh orchestrator) connect to host at `SSH_ADDR` in IP:PORT format",
+			EnvVar: "SSH_ADDR",
+		},
+		cli.StringFlag{
+			Name: "sshUser",
+			Value: "",
+			Usage: "(systemd/ssh orchestrator) authenticate as `SSH_USER`",
+			EnvVar: "SSH_USER",
+		},
+		cli.StringFlag{
+			Name: "sshKeyFile",
+			Value: "",
+			Usage: "(systemd/ssh orchestrator) authenticate using private key file at `SSH_KEY_FILE`",
+			EnvVar: "SSH_KEY_FILE",
+		},
+		cli.StringFlag{
+			Name: "sshKnownHostsFile",
+			Value: "",
+			Usage: "(systemd/ssh orchestrator) verify the remote host key against known_hosts file at `SSH_KNOWN_HOSTS_FILE`",
+			EnvVar: "SSH_KNOWN_HOSTS_FILE",
+		},
+		cli.StringFlag{
+			Name: "credentialsRef",
+			Value: "",
+			Usage: "resolve awsAccessKey/awsSecretKey from `CREDENTIALS_REF` instead (one of: iam, vault://<path>, k8s://<mount-path>, secret://<name>)",
+			EnvVar: "CREDENTIALS_REF",
+		},
+		cli.StringFlag{
+			Name: "archiveStrategy",
+			Value: "tar",
+			Usage: "produce backups using `ARCHIVE_STRATEGY` (one of: tar, incremental)",
+			EnvVar: "ARCHIVE_STRATEGY",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
-		err := runOuter(config{
+		cfg := config{
 			c.String("fleetEndpoint"), // fleet
 			c.String("socksProxy"),    // fleet
 			c.String("awsAccessKey"),  // S3
@@ -100,7 +323,49 @@ func main() {
 			c.String("s3Domain"),      // S3
 			c.String("bucketName"),    // S3
 			c.String("env"),           // filesystem
-		})
+			c.String("backend"),            // storage backend
+			c.String("gcsBucket"),          // GCS
+			c.String("gcsCredentialsFile"), // GCS
+			c.String("azureAccount"),       // Azure
+			c.String("azureKey"),           // Azure
+			c.String("azureContainer"),     // Azure
+			c.String("localBackupDir"),     // local
+			c.String("sftpAddr"),           // SFTP
+			c.String("sftpUser"),           // SFTP
+			c.String("sftpKeyFile"),        // SFTP
+			c.String("sftpRemoteDir"),      // SFTP
+			c.String("sftpKnownHostsFile"), // SFTP
+			c.String("schedule"),           // scheduler
+			c.Int("retentionDays"),         // scheduler
+			c.Int("retentionCount"),        // scheduler
+			c.String("adminAddr"),          // scheduler
+			c.Bool("sse"),                     // encryption
+			c.String("kmsKeyId"),               // encryption
+			c.String("encryptionPassphrase"),   // encryption
+			c.String("notify-url"),             // notifications
+			c.String("metrics-addr"),           // metrics
+			c.String("pushgateway"),            // metrics
+			c.String("orchestrator"),           // orchestrator
+			c.String("k8sNamespace"),           // orchestrator (k8s)
+			c.String("k8sStatefulSet"),         // orchestrator (k8s)
+			c.String("k8sKubeconfig"),          // orchestrator (k8s)
+			c.String("sshAddr"),                // orchestrator (ssh)
+			c.String("sshUser"),                // orchestrator (ssh)
+			c.String("sshKeyFile"),             // orchestrator (ssh)
+			c.String("sshKnownHostsFile"),      // orchestrator (ssh)
+			c.String("credentialsRef"),         // credentials
+			c.String("archiveStrategy"),        // archive strategy
+		}
+
+		if cfg.schedule != "" {
+			err := runScheduler(cfg)
+			if err != nil {
+				os.Exit(1)
+			}
+			return err
+		}
+
+		err := runOuter(cfg)
 		if err != nil {
 			os.Exit(1)
 		}
@@ -108,44 +373,190 @@ func main() {
 		return err
 	}
 
+	app.Commands = []cli.Command{
+		{
+			Name: "restore",
+			Usage: "download an archive (or, with --snapshot, an incremental snapshot) from the storage backend",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "archiveName", Usage: "name of the archive to restore, as stored in the bucket"},
+				cli.StringFlag{Name: "snapshot", Usage: "id of an incremental snapshot to restore instead of a tar archive"},
+				cli.StringFlag{Name: "targetPath", Usage: "local path to write the restored archive/snapshot to"},
+			},
+			Action: func(c *cli.Context) error {
+				cfg := restoreCommandConfig(c)
+				var err error
+				if snapshotID := c.String("snapshot"); snapshotID != "" {
+					err = runRestoreSnapshot(cfg, snapshotID, c.String("targetPath"))
+				} else {
+					err = runRestore(cfg, c.String("archiveName"), c.String("targetPath"))
+				}
+				if err != nil {
+					os.Exit(1)
+				}
+				return err
+			},
+		},
+		{
+			Name: "list-snapshots",
+			Usage: "list the ids of incremental snapshots stored in the bucket",
+			Action: func(c *cli.Context) error {
+				cfg := restoreCommandConfig(c)
+				storageBackend, err := newStorageBackend(cfg.backend, cfg)
+				if err != nil {
+					log.WithFields(log.Fields{"backend": cfg.backend, "err": err}).Error("Error instantiating storage backend.")
+					os.Exit(1)
+				}
+				ids, err := listSnapshots(storageBackend)
+				if err != nil {
+					log.WithFields(log.Fields{"err": err}).Error("Error listing snapshots.")
+					os.Exit(1)
+				}
+				for _, id := range ids {
+					fmt.Println(id)
+				}
+				return nil
+			},
+		},
+	}
+
 	app.Run(os.Args)
 }
 
+// restoreCommandConfig builds a config from the global flags shared by the
+// restore and list-snapshots subcommands, which only need the storage
+// backend settings, not the fleet/scheduler/notification flags.
+func restoreCommandConfig(c *cli.Context) config {
+	return config{
+		awsAccessKey: c.GlobalString("awsAccessKey"),
+		awsSecretKey: c.GlobalString("awsSecretKey"),
+		s3Domain: c.GlobalString("s3Domain"),
+		bucketName: c.GlobalString("bucketName"),
+		backend: c.GlobalString("backend"),
+		gcsBucket: c.GlobalString("gcsBucket"),
+		gcsCredentialsFile: c.GlobalString("gcsCredentialsFile"),
+		azureAccount: c.GlobalString("azureAccount"),
+		azureKey: c.GlobalString("azureKey"),
+		azureContainer: c.GlobalString("azureContainer"),
+		localBackupDir: c.GlobalString("localBackupDir"),
+		sftpAddr: c.GlobalString("sftpAddr"),
+		sftpUser: c.GlobalString("sftpUser"),
+		sftpKeyFile: c.GlobalString("sftpKeyFile"),
+		sftpRemoteDir: c.GlobalString("sftpRemoteDir"),
+		sftpKnownHostsFile: c.GlobalString("sftpKnownHostsFile"),
+		encryptionPassphrase: c.GlobalString("encryptionPassphrase"),
+		credentialsRef: c.GlobalString("credentialsRef"),
+	}
+}
+
+// runRestoreSnapshot resolves a storage backend from cfg and restores an
+// incremental snapshot created by createIncrementalBackup to targetPath.
+func runRestoreSnapshot(cfg config, snapshotID string, targetPath string) error {
+	storageBackend, err := newStorageBackend(cfg.backend, cfg)
+	if err != nil {
+		log.WithFields(log.Fields{"backend": cfg.backend, "err": err}).Error("Error instantiating storage backend; restore failed.")
+		return err
+	}
+	return restoreSnapshot(storageBackend, snapshotID, targetPath, cfg.encryptionPassphrase)
+}
+
 func runOuter(cfg config) (error) {
 
-	fleetClient, err := newFleetClient(cfg.fleetEndpoint, cfg.socksProxy)
+	if cfg.metricsAddr != "" {
+		go func() {
+			if err := serveMetrics(cfg.metricsAddr); err != nil {
+				log.WithFields(log.Fields{"metricsAddr": cfg.metricsAddr, "err": err}).Warn("Metrics server stopped unexpectedly.")
+			}
+		}()
+	}
+
+	var fleetClient fleetAPI
+	var err error
+	if cfg.orchestrator == "" || cfg.orchestrator == "fleet" {
+		fleetClient, err = newFleetClient(cfg.fleetEndpoint, cfg.socksProxy)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"fleetEndpoint": cfg.fleetEndpoint,
+				"socksProxy": cfg.socksProxy,
+				"err": err,
+			}).Error("Error instantiating fleet client; backup process failed.")
+			return err
+		}
+	}
+	orchestrator, err := newOrchestrator(cfg.orchestrator, cfg, fleetClient)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"fleetEndpoint": cfg.fleetEndpoint,
-			"socksProxy": cfg.socksProxy,
-			"err": err,
-		}).Error("Error instantiating fleet client; backup process failed.")
+		log.WithFields(log.Fields{"orchestrator": cfg.orchestrator, "err": err}).Error("Error instantiating orchestrator; backup process failed.")
 		return err
 	}
 	archiveName := fmt.Sprintf("neo4j_backup_%s_%s.tar.gz", time.Now().UTC().Format(archiveNameDateFormat), cfg.env)
 
-	bucketWriter, err := newBucketWriter(cfg.awsAccessKey, cfg.awsSecretKey, cfg.s3Domain, cfg.bucketName, archiveName)
+	storageBackend, err := newStorageBackend(cfg.backend, cfg)
+	if err != nil {
+		log.WithFields(log.Fields{"backend": cfg.backend, "err": err}).Error("Error instantiating storage backend; backup process failed.")
+		return err
+	}
+	bucketWriter, err := newBucketWriter(storageBackend, archiveName)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Error instantiating bucket writer; backup process failed.")
+		return err
+	}
+	bucketWriter, err = wrapWithClientEncryption(bucketWriter, cfg.encryptionPassphrase)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Error setting up client-side encryption; backup process failed.")
+		return err
+	}
+
+	backupNotifier, err := newNotifier(cfg.notifyURL)
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Error("Error instantiating S3 bucket writer; backup process failed.")
+		log.WithFields(log.Fields{"notifyURL": cfg.notifyURL, "err": err}).Error("Error instantiating notifier; backup process failed.")
 		return err
 	}
 
-	return runInner(fleetClient, bucketWriter, cfg.dataFolder, cfg.targetFolder, archiveName)
+	runErr := runInner(orchestrator, bucketWriter, cfg.dataFolder, cfg.targetFolder, archiveName, backupNotifier, storageBackend, cfg.archiveStrategy, cfg.env, cfg.encryptionPassphrase)
+	if cfg.pushgatewayURL != "" {
+		if pushErr := pushMetrics(cfg.pushgatewayURL, cfg.env); pushErr != nil {
+			log.WithFields(log.Fields{"err": pushErr}).Warn("Error pushing metrics to Pushgateway.")
+		}
+	}
+	return runErr
 }
 
 func runInner(
-	fleetClient fleetAPI,
+	orchestrator Orchestrator,
 	bucketWriter io.WriteCloser,
 	dataFolder string,
 	targetFolder string,
 	archiveName string,
-	) (error) {
+	notifier *notifier,
+	storageBackend StorageBackend,
+	archiveStrategy string,
+	env string,
+	encryptionPassphrase string,
+	) (err error) {
+
+	runStart := time.Now()
+	stages := map[string]time.Duration{}
+	var archiveSize int64
+	defer func() {
+		report := backupReport{
+			ArchiveName: archiveName,
+			Duration: time.Since(runStart),
+			Size: archiveSize,
+			Stages: stages,
+			Error: err,
+		}
+		recordBackupMetrics(report)
+		if notifyErr := notifier.notify(report); notifyErr != nil {
+			log.WithFields(log.Fields{"err": notifyErr}).Warn("Error sending backup notification.")
+		}
+	}()
 
 	log.WithFields(log.Fields{
 		"dataFolder": dataFolder,
 		"targetFolder": targetFolder,
 	}).Info("Starting first hot rsync process.")
-	err := rsync(dataFolder, targetFolder)
+	hotRsyncStart := time.Now()
+	err = rsync(dataFolder, targetFolder)
+	stages["rsync-hot"] = time.Since(hotRsyncStart)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"dataFolder": dataFolder,
@@ -164,7 +575,8 @@ func runInner(
 		}
 	}
 	log.Info("Hot rsync completed, shutting down neo...")
-	err = shutDownNeo(fleetClient)
+	downtimeStart := time.Now()
+	err = shutDownNeo(orchestrator)
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Error("Error shutting down neo4j; backup process failed.")
 		return err
@@ -173,7 +585,9 @@ func runInner(
 		"dataFolder": dataFolder,
 		"targetFolder": targetFolder,
 	}).Info("Starting cold rsync process...")
+	coldRsyncStart := time.Now()
 	err = rsync(dataFolder, targetFolder)
+	stages["rsync-cold"] = time.Since(coldRsyncStart)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"dataFolder": dataFolder,
@@ -183,19 +597,38 @@ func runInner(
 		return err
 	}
 	log.Info("cold rsync completed, restarting neo...")
-	err = startNeo(fleetClient)
+	err = startNeo(orchestrator)
+	stages["downtime"] = time.Since(downtimeStart)
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Error("Error starting up neo4j.")
 		return err
 	}
+	if archiveStrategy == "incremental" {
+		log.Info("neo has been started up, commencing incremental snapshot creation...")
+		snapshotStart := time.Now()
+		archiveSize, err = createIncrementalBackup(storageBackend, targetFolder, env, archiveName, encryptionPassphrase)
+		stages["snapshot"] = time.Since(snapshotStart)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("Error creating incremental snapshot; backup process failed.")
+			return err
+		}
+		validateEnvironment()
+		log.WithFields(log.Fields{"snapshotID": archiveName}).Info("Incremental snapshot uploaded successfully; backup process complete.")
+		return nil
+	}
+
 	log.Info("neo has been started up, commencing archive creation...")
+	tarStart := time.Now()
 	pipeReader, err := createBackup(targetFolder, archiveName)
+	stages["tar"] = time.Since(tarStart)
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Error("Error creating backup tarball.")
 		return err
 	}
 	log.WithFields(log.Fields{"archiveName": archiveName, "err": err}).Info("Initial tar/gzip archive created, streaming data to S3 as it is added to the archive...")
-	err = uploadToS3(bucketWriter, pipeReader)
+	uploadStart := time.Now()
+	archiveSize, err = uploadToS3(bucketWriter, pipeReader)
+	stages["upload"] = time.Since(uploadStart)
 	if err != nil {
 		log.WithFields(log.Fields{"archiveName": archiveName, "err": err}).Error("Error uploading to S3; backup process failed.")
 		return err
@@ -205,24 +638,23 @@ func runInner(
 	return nil
 }
 
-func newBucketWriter(awsAccessKey string, awsSecretKey string, s3Domain string, bucketName string, archiveName string) (io.WriteCloser, error) {
-	bucketWriterProvider := newS3WriterProvider(awsAccessKey, awsSecretKey, s3Domain, bucketName)
-	bucketWriter, err := bucketWriterProvider.getWriter(archiveName)
+func newBucketWriter(storageBackend StorageBackend, archiveName string) (io.WriteCloser, error) {
+	bucketWriter, err := storageBackend.GetWriter(archiveName)
 	if err != nil {
 		log.Error("BucketWriter cannot be created: "+err.Error(), err)
 	}
 	return bucketWriter, err
 }
 
-func uploadToS3(bucketWriter io.WriteCloser, pipeReader *io.PipeReader) (err error) {
+func uploadToS3(bucketWriter io.WriteCloser, pipeReader *io.PipeReader) (size int64, err error) {
 	defer bucketWriter.Close()
 
 	//upload the archive to the bucket
-	_, err = io.Copy(bucketWriter, pipeReader)
+	size, err = io.Copy(bucketWriter, pipeReader)
 	if err != nil {
 		log.Error("Cannot upload archive to S3: "+err.Error(), err)
-		return err
+		return size, err
 	}
 	pipeReader.Close()
-	return nil
+	return size, nil
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// backupMetrics instruments runInner so the previously opaque backup
+// pipeline is observable via a /metrics endpoint and/or a Prometheus
+// Pushgateway, instead of only via log lines.
+var backupMetrics = struct {
+	stageDuration   *prometheus.HistogramVec
+	downtimeWindow  prometheus.Histogram
+	archiveBytes    prometheus.Histogram
+	uploadBytes     prometheus.Histogram
+	s3Errors        prometheus.Counter
+	lastSuccessTime prometheus.Gauge
+}{
+	stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "neo4j_backup_stage_duration_seconds",
+		Help: "Duration of each backup stage (rsync-hot, rsync-cold, downtime, tar, upload, snapshot).",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"stage"}),
+	downtimeWindow: prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "neo4j_backup_downtime_seconds",
+		Help: "Duration neo4j was shut down for the cold rsync phase.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}),
+	archiveBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "neo4j_backup_archive_bytes",
+		Help: "Size in bytes of the tar/gzip archive produced.",
+		Buckets: prometheus.ExponentialBuckets(1<<20, 2, 16),
+	}),
+	uploadBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "neo4j_backup_upload_bytes",
+		Help: "Size in bytes uploaded to the storage backend.",
+		Buckets: prometheus.ExponentialBuckets(1<<20, 2, 16),
+	}),
+	s3Errors: prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "neo4j_backup_s3_errors_total",
+		Help: "Count of errors encountered uploading to S3.",
+	}),
+	lastSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "neo4j_backup_last_success_timestamp_seconds",
+		Help: "Unix timestamp of the last backup that completed successfully.",
+	}),
+}
+
+func init() {
+	prometheus.MustRegister(
+		backupMetrics.stageDuration,
+		backupMetrics.downtimeWindow,
+		backupMetrics.archiveBytes,
+		backupMetrics.uploadBytes,
+		backupMetrics.s3Errors,
+		backupMetrics.lastSuccessTime,
+	)
+}
+
+// recordBackupMetrics updates the package's Prometheus metrics from a
+// completed backupReport, and is called from runInner's deferred notifier.
+func recordBackupMetrics(report backupReport) {
+	for stage, duration := range report.Stages {
+		backupMetrics.stageDuration.WithLabelValues(stage).Observe(duration.Seconds())
+	}
+	if downtime, ok := report.Stages["downtime"]; ok {
+		backupMetrics.downtimeWindow.Observe(downtime.Seconds())
+	}
+	backupMetrics.uploadBytes.Observe(float64(report.Size))
+	backupMetrics.archiveBytes.Observe(float64(report.Size))
+	if report.Error != nil {
+		// runInner records the upload (or snapshot) stage only once it has
+		// reached it, and returns right after it, so a failed run with that
+		// stage recorded failed while uploading.
+		_, uploaded := report.Stages["upload"]
+		_, snapshotted := report.Stages["snapshot"]
+		if uploaded || snapshotted {
+			backupMetrics.s3Errors.Inc()
+		}
+	} else {
+		backupMetrics.lastSuccessTime.Set(float64(time.Now().Unix()))
+	}
+}
+
+// serveMetrics exposes backupMetrics on a /metrics endpoint at addr. It runs
+// for the lifetime of the process, so callers should invoke it in its own
+// goroutine.
+func serveMetrics(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.WithFields(log.Fields{"metricsAddr": addr}).Info("Serving Prometheus metrics.")
+	return http.ListenAndServe(addr, mux)
+}
+
+// pushMetrics pushes backupMetrics to a Prometheus Pushgateway, for
+// short-lived cron invocations that would otherwise never be scraped. It
+// groups by env rather than by archive name: the Pushgateway never garbage
+// collects groups, so grouping by a value that's unique per run (like an
+// archive name, which embeds a timestamp) would accumulate one abandoned
+// group per backup forever. Grouping by env means each invocation instead
+// overwrites the same group, which is also the behaviour operators want
+// when scraping "the latest backup for this env".
+func pushMetrics(pushgatewayURL string, env string) error {
+	if pushgatewayURL == "" {
+		return nil
+	}
+	pusher := push.New(pushgatewayURL, "neo4j_backup").
+		Grouping("env", env).
+		Collector(backupMetrics.stageDuration).
+		Collector(backupMetrics.downtimeWindow).
+		Collector(backupMetrics.archiveBytes).
+		Collector(backupMetrics.uploadBytes).
+		Collector(backupMetrics.s3Errors).
+		Collector(backupMetrics.lastSuccessTime)
+	if err := pusher.Push(); err != nil {
+		log.WithFields(log.Fields{"pushgatewayURL": pushgatewayURL, "err": err}).Warn("Error pushing metrics to Pushgateway.")
+		return err
+	}
+	return nil
+}
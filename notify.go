@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/containrrr/shoutrrr"
+)
+
+const defaultSuccessTemplate = `Neo4j backup succeeded.
+Archive: {{ .ArchiveName }}
+Duration: {{ .Duration }}
+Size: {{ .Size }} bytes
+Stages: {{ range $stage, $dur := .Stages }}{{ $stage }}={{ $dur }} {{ end }}`
+
+const defaultFailureTemplate = `Neo4j backup FAILED.
+Archive: {{ .ArchiveName }}
+Duration: {{ .Duration }}
+Error: {{ .Error }}
+Stages: {{ range $stage, $dur := .Stages }}{{ $stage }}={{ $dur }} {{ end }}`
+
+// backupReport carries the data available to --notify-url message templates.
+type backupReport struct {
+	ArchiveName string
+	Duration    time.Duration
+	Size        int64
+	Stages      map[string]time.Duration
+	Error       error
+}
+
+// notifier sends a templated backupReport to a shoutrrr service URL
+// (Slack, Discord, email, Matrix, PagerDuty, etc.) at the end of a backup
+// run, replacing the previous fire-and-forget logging on failure paths.
+// A nil notifier, or one with an empty url, is a silent no-op so
+// --notify-url remains optional.
+type notifier struct {
+	url             string
+	successTemplate *template.Template
+	failureTemplate *template.Template
+}
+
+func newNotifier(notifyURL string) (*notifier, error) {
+	successTemplate, err := template.New("success").Parse(defaultSuccessTemplate)
+	if err != nil {
+		return nil, err
+	}
+	failureTemplate, err := template.New("failure").Parse(defaultFailureTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return &notifier{url: notifyURL, successTemplate: successTemplate, failureTemplate: failureTemplate}, nil
+}
+
+func (n *notifier) notify(report backupReport) error {
+	if n == nil || n.url == "" {
+		return nil
+	}
+	tmpl := n.successTemplate
+	if report.Error != nil {
+		tmpl = n.failureTemplate
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, report); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"archiveName": report.ArchiveName}).Info("Sending backup notification.")
+	return shoutrrr.Send(n.url, buf.String())
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Orchestrator abstracts how the neo4j service is stopped and started, so
+// shutDownNeo/startNeo are no longer hard-wired to fleet (which is EOL).
+// Selected at runtime via the --orchestrator flag.
+type Orchestrator interface {
+	Stop(service string) error
+	Start(service string) error
+	IsActive(service string) (bool, error)
+	WaitFor(service string, state string, timeout time.Duration) error
+}
+
+func newOrchestrator(kind string, cfg config, fleetClient fleetAPI) (Orchestrator, error) {
+	switch kind {
+	case "", "fleet":
+		return newFleetOrchestrator(fleetClient), nil
+	case "k8s":
+		return newK8sOrchestrator(cfg.k8sNamespace, cfg.k8sStatefulSet, cfg.k8sKubeconfig)
+	case "systemd", "ssh":
+		return newSSHOrchestrator(cfg.sshAddr, cfg.sshUser, cfg.sshKeyFile, cfg.sshKnownHostsFile)
+	default:
+		return nil, fmt.Errorf("unrecognised orchestrator %q: must be one of fleet, k8s, systemd, ssh", kind)
+	}
+}
+
+// pollUntilState is a generic implementation of WaitFor built on top of
+// IsActive, shared by orchestrators whose underlying API has no native
+// "wait" primitive.
+func pollUntilState(orchestrator Orchestrator, serviceName string, state string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	wantActive := state == "active"
+	for time.Now().Before(deadline) {
+		isActive, err := orchestrator.IsActive(serviceName)
+		if err != nil {
+			return err
+		}
+		if isActive == wantActive {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("timed out after %s waiting for %s to reach state %q", timeout, serviceName, state)
+}
+
+// k8sOrchestrator drives a Kubernetes StatefulSet: "stopping" scales it to
+// zero replicas and waits for its pod to terminate, "starting" scales it
+// back up.
+type k8sOrchestrator struct {
+	clientset      *kubernetes.Clientset
+	namespace      string
+	statefulSetName string
+}
+
+func newK8sOrchestrator(namespace string, statefulSetName string, kubeconfig string) (*k8sOrchestrator, error) {
+	var restConfig *rest.Config
+	var err error
+	if kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", "")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"kubeconfig": kubeconfig, "err": err}).Error("Error building Kubernetes client config.")
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &k8sOrchestrator{clientset: clientset, namespace: namespace, statefulSetName: statefulSetName}, nil
+}
+
+func (o *k8sOrchestrator) scale(replicas int32) error {
+	statefulSets := o.clientset.AppsV1().StatefulSets(o.namespace)
+	scale, err := statefulSets.GetScale(o.statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	scale.Spec.Replicas = replicas
+	_, err = statefulSets.UpdateScale(o.statefulSetName, scale)
+	return err
+}
+
+// Stop and Start take a service name to satisfy Orchestrator, but a
+// StatefulSet scales as a whole; the name is only used for logging here.
+func (o *k8sOrchestrator) Stop(serviceName string) error {
+	log.WithFields(log.Fields{"statefulSet": o.statefulSetName, "service": serviceName}).Info("Scaling StatefulSet to 0 replicas.")
+	return o.scale(0)
+}
+
+func (o *k8sOrchestrator) Start(serviceName string) error {
+	log.WithFields(log.Fields{"statefulSet": o.statefulSetName, "service": serviceName}).Info("Scaling StatefulSet to 1 replica.")
+	return o.scale(1)
+}
+
+func (o *k8sOrchestrator) IsActive(serviceName string) (bool, error) {
+	pods, err := o.clientset.CoreV1().Pods(o.namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("statefulset.kubernetes.io/pod-name=%s-0", o.statefulSetName),
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == "Running" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (o *k8sOrchestrator) WaitFor(serviceName string, state string, timeout time.Duration) error {
+	return pollUntilState(o, serviceName, state, timeout)
+}
+
+// sshOrchestrator drives a systemd unit on a remote host over SSH, for
+// sites running neo4j on plain VMs without fleet or Kubernetes.
+type sshOrchestrator struct {
+	client *ssh.Client
+}
+
+func newSSHOrchestrator(addr string, user string, keyFile string, knownHostsFile string) (*sshOrchestrator, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		log.WithFields(log.Fields{"keyFile": keyFile, "err": err}).Error("Error reading SSH private key.")
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := sshHostKeyCallback(knownHostsFile)
+	if err != nil {
+		log.WithFields(log.Fields{"knownHostsFile": knownHostsFile, "err": err}).Error("Error setting up SSH host-key verification.")
+		return nil, err
+	}
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"addr": addr, "err": err}).Error("Error connecting over SSH.")
+		return nil, err
+	}
+	return &sshOrchestrator{client: client}, nil
+}
+
+func (o *sshOrchestrator) runCommand(command string) (string, error) {
+	session, err := o.client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	output, err := session.CombinedOutput(command)
+	return string(output), err
+}
+
+func (o *sshOrchestrator) Stop(serviceName string) error {
+	_, err := o.runCommand(fmt.Sprintf("sudo systemctl stop %s", serviceName))
+	return err
+}
+
+func (o *sshOrchestrator) Start(serviceName string) error {
+	_, err := o.runCommand(fmt.Sprintf("sudo systemctl start %s", serviceName))
+	return err
+}
+
+func (o *sshOrchestrator) IsActive(serviceName string) (bool, error) {
+	output, err := o.runCommand(fmt.Sprintf("systemctl is-active %s", serviceName))
+	// systemctl is-active exits non-zero for inactive units; that's not a
+	// real error, it's the answer to the question being asked.
+	if _, isExitErr := err.(*ssh.ExitError); err != nil && !isExitErr {
+		return false, err
+	}
+	return strings.TrimSpace(output) == "active", nil
+}
+
+func (o *sshOrchestrator) WaitFor(serviceName string, state string, timeout time.Duration) error {
+	return pollUntilState(o, serviceName, state, timeout)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robfig/cron"
+)
+
+// lastRunStatus records the outcome of the most recently completed backup,
+// so the admin endpoint can report on it without re-running anything.
+type lastRunStatus struct {
+	mu          sync.RWMutex
+	archiveName string
+	startedAt   time.Time
+	finishedAt  time.Time
+	err         error
+	running     bool
+}
+
+func (s *lastRunStatus) markStarted(archiveName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.archiveName = archiveName
+	s.startedAt = time.Now()
+	s.finishedAt = time.Time{}
+	s.err = nil
+	s.running = true
+}
+
+func (s *lastRunStatus) markFinished(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishedAt = time.Now()
+	s.err = err
+	s.running = false
+}
+
+func (s *lastRunStatus) snapshot() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	errStr := ""
+	if s.err != nil {
+		errStr = s.err.Error()
+	}
+	return map[string]interface{}{
+		"archiveName": s.archiveName,
+		"startedAt":   s.startedAt,
+		"finishedAt":  s.finishedAt,
+		"running":     s.running,
+		"error":       errStr,
+	}
+}
+
+// runScheduler runs the backup process as a long-running daemon, invoking
+// runInner on a cron schedule and pruning old archives from the storage
+// backend according to cfg.retentionDays/cfg.retentionCount, rather than
+// relying on an external cron invoking a one-shot process. It also serves a
+// small HTTP admin endpoint for triggering an ad-hoc backup and querying the
+// status of the last run.
+func runScheduler(cfg config) error {
+	var fleetClient fleetAPI
+	var err error
+	if cfg.orchestrator == "" || cfg.orchestrator == "fleet" {
+		fleetClient, err = newFleetClient(cfg.fleetEndpoint, cfg.socksProxy)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("Error instantiating fleet client; scheduler failed to start.")
+			return err
+		}
+	}
+	orchestrator, err := newOrchestrator(cfg.orchestrator, cfg, fleetClient)
+	if err != nil {
+		log.WithFields(log.Fields{"orchestrator": cfg.orchestrator, "err": err}).Error("Error instantiating orchestrator; scheduler failed to start.")
+		return err
+	}
+	storageBackend, err := newStorageBackend(cfg.backend, cfg)
+	if err != nil {
+		log.WithFields(log.Fields{"backend": cfg.backend, "err": err}).Error("Error instantiating storage backend; scheduler failed to start.")
+		return err
+	}
+	backupNotifier, err := newNotifier(cfg.notifyURL)
+	if err != nil {
+		log.WithFields(log.Fields{"notifyURL": cfg.notifyURL, "err": err}).Error("Error instantiating notifier; scheduler failed to start.")
+		return err
+	}
+
+	status := &lastRunStatus{}
+	runOnce := func() error {
+		archiveName := fmt.Sprintf("neo4j_backup_%s_%s.tar.gz", time.Now().UTC().Format(archiveNameDateFormat), cfg.env)
+		status.markStarted(archiveName)
+		bucketWriter, err := newBucketWriter(storageBackend, archiveName)
+		if err != nil {
+			status.markFinished(err)
+			return err
+		}
+		bucketWriter, err = wrapWithClientEncryption(bucketWriter, cfg.encryptionPassphrase)
+		if err != nil {
+			status.markFinished(err)
+			return err
+		}
+		err = runInner(orchestrator, bucketWriter, cfg.dataFolder, cfg.targetFolder, archiveName, backupNotifier, storageBackend, cfg.archiveStrategy, cfg.env, cfg.encryptionPassphrase)
+		status.markFinished(err)
+		if cfg.pushgatewayURL != "" {
+			if pushErr := pushMetrics(cfg.pushgatewayURL, cfg.env); pushErr != nil {
+				log.WithFields(log.Fields{"err": pushErr}).Warn("Error pushing metrics to Pushgateway.")
+			}
+		}
+		if err == nil {
+			if (cfg.retentionDays > 0 || cfg.retentionCount > 0) && cfg.archiveStrategy == "incremental" {
+				log.Warn("retentionDays/retentionCount have no effect with --archiveStrategy incremental: " +
+					"snapshots/ and packs/ objects are never pruned by pruneArchives, so the bucket will grow unbounded.")
+			}
+			if pruneErr := pruneArchives(storageBackend, cfg.retentionDays, cfg.retentionCount); pruneErr != nil {
+				log.WithFields(log.Fields{"err": pruneErr}).Warn("Error pruning old archives from storage backend.")
+			}
+		}
+		return err
+	}
+
+	c := cron.New()
+	if err := c.AddFunc(cfg.schedule, func() {
+		log.WithFields(log.Fields{"schedule": cfg.schedule}).Info("Scheduled backup triggered.")
+		if err := runOnce(); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("Scheduled backup failed.")
+		}
+	}); err != nil {
+		log.WithFields(log.Fields{"schedule": cfg.schedule, "err": err}).Error("Error parsing backup schedule; scheduler failed to start.")
+		return err
+	}
+	c.Start()
+	defer c.Stop()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status.snapshot())
+	})
+	mux.HandleFunc("/backup", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		go func() {
+			log.Info("Ad-hoc backup triggered via admin endpoint.")
+			if err := runOnce(); err != nil {
+				log.WithFields(log.Fields{"err": err}).Error("Ad-hoc backup failed.")
+			}
+		}()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	if cfg.metricsAddr == cfg.adminAddr {
+		mux.Handle("/metrics", promhttp.Handler())
+	} else if cfg.metricsAddr != "" {
+		go func() {
+			if err := serveMetrics(cfg.metricsAddr); err != nil {
+				log.WithFields(log.Fields{"metricsAddr": cfg.metricsAddr, "err": err}).Warn("Metrics server stopped unexpectedly.")
+			}
+		}()
+	}
+
+	log.WithFields(log.Fields{"adminAddr": cfg.adminAddr, "schedule": cfg.schedule}).Info(
+		"Scheduler started, serving admin endpoint.")
+	return http.ListenAndServe(cfg.adminAddr, mux)
+}
+
+// archiveNamePrefix is the prefix every tar/gzip archive is stored under;
+// pruneArchives uses it to tell archives apart from the packs/ and
+// snapshots/ objects an incremental-strategy bucket also holds. An
+// incremental snapshot reuses the archive name as its snapshotID, but its
+// index is stored as snapshots/<snapshotID>.json, which does not carry the
+// prefix.
+const archiveNamePrefix = "neo4j_backup_"
+
+// pruneArchives deletes archives from the storage backend older than
+// retentionDays and keeps at most retentionCount of the most recent ones.
+// A zero value for either disables that rule. Only objects named like an
+// archive (neo4j_backup_<timestamp>...) are considered; this is what keeps
+// it from deleting (or failing to delete) the packs/<prefix>/<id> and
+// snapshots/<timestamp>.json objects an --archiveStrategy incremental bucket
+// also holds, none of which are individually "an archive" to prune.
+func pruneArchives(storageBackend StorageBackend, retentionDays int, retentionCount int) error {
+	allNames, err := storageBackend.List()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, name := range allNames {
+		if strings.HasPrefix(name, archiveNamePrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	toDelete := map[string]bool{}
+	if retentionCount > 0 && len(names) > retentionCount {
+		for _, name := range names[:len(names)-retentionCount] {
+			toDelete[name] = true
+		}
+	}
+	if retentionDays > 0 {
+		cutoff := time.Now().UTC().Add(-time.Duration(retentionDays) * 24 * time.Hour).Format(archiveNameDateFormat)
+		for _, name := range names {
+			if name < fmt.Sprintf("%s%s", archiveNamePrefix, cutoff) {
+				toDelete[name] = true
+			}
+		}
+	}
+
+	for name := range toDelete {
+		log.WithFields(log.Fields{"archiveName": name}).Info("Pruning archive past retention policy.")
+		if err := storageBackend.Delete(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshHostKeyCallback builds a HostKeyCallback from a known_hosts file, shared
+// by newSSHOrchestrator and newSFTPBackend so neither --orchestrator ssh nor
+// --backend sftp is left open to a MITM by skipping host-key verification.
+// knownHostsFile is required: there is no "insecure" fallback, so a
+// misconfigured deployment fails fast at startup instead of connecting
+// unverified.
+func sshHostKeyCallback(knownHostsFile string) (ssh.HostKeyCallback, error) {
+	if knownHostsFile == "" {
+		return nil, fmt.Errorf("a known_hosts file is required to verify the remote host key; none was configured")
+	}
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading known_hosts file %q: %v", knownHostsFile, err)
+	}
+	return callback, nil
+}
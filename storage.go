@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// StorageBackend abstracts the destination a backup archive is written to.
+// newBucketWriter used to hard-code S3; this lets operators who aren't on
+// AWS point the tool at GCS, Azure Blob, a local path, or an SFTP server
+// instead, while S3 stays the default so existing deployments are unaffected.
+type StorageBackend interface {
+	GetWriter(name string) (io.WriteCloser, error)
+	GetReader(name string) (io.ReadCloser, error)
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+func newStorageBackend(backend string, cfg config) (StorageBackend, error) {
+	switch backend {
+	case "", "s3":
+		awsAccessKey, awsSecretKey, err := resolveCredentials(cfg.awsAccessKey, cfg.awsSecretKey, CredentialsRef{Ref: cfg.credentialsRef})
+		if err != nil {
+			return nil, err
+		}
+		return newS3Backend(awsAccessKey, awsSecretKey, cfg.s3Domain, cfg.bucketName, sseOptions{enabled: cfg.sseEnabled, kmsKeyID: cfg.kmsKeyID}), nil
+	case "gcs":
+		return newGCSBackend(cfg.gcsBucket, cfg.gcsCredentialsFile)
+	case "azure":
+		return newAzureBackend(cfg.azureAccount, cfg.azureKey, cfg.azureContainer)
+	case "local":
+		return newLocalBackend(cfg.localBackupDir), nil
+	case "sftp":
+		return newSFTPBackend(cfg.sftpAddr, cfg.sftpUser, cfg.sftpKeyFile, cfg.sftpRemoteDir, cfg.sftpKnownHostsFile)
+	default:
+		return nil, fmt.Errorf("unrecognised backend %q: must be one of s3, gcs, azure, local, sftp", backend)
+	}
+}
+
+// s3Backend preserves the existing AWS S3 behaviour via newS3WriterProvider.
+type s3Backend struct {
+	provider *s3WriterProvider
+	sse      sseOptions
+}
+
+func newS3Backend(awsAccessKey string, awsSecretKey string, s3Domain string, bucketName string, sse sseOptions) *s3Backend {
+	return &s3Backend{provider: newS3WriterProvider(awsAccessKey, awsSecretKey, s3Domain, bucketName), sse: sse}
+}
+
+func (b *s3Backend) GetWriter(name string) (io.WriteCloser, error) {
+	if b.sse.enabled || b.sse.kmsKeyID != "" {
+		return b.provider.getWriterWithSSE(name, b.sse.kmsKeyID)
+	}
+	return b.provider.getWriter(name)
+}
+
+func (b *s3Backend) GetReader(name string) (io.ReadCloser, error) {
+	return b.provider.getReader(name)
+}
+
+func (b *s3Backend) List() ([]string, error) {
+	return b.provider.list()
+}
+
+func (b *s3Backend) Delete(name string) error {
+	return b.provider.delete(name)
+}
+
+// gcsBackend writes archives to a Google Cloud Storage bucket.
+type gcsBackend struct {
+	client *storage.Client
+	bucket string
+}
+
+func newGCSBackend(bucket string, credentialsFile string) (*gcsBackend, error) {
+	ctx := context.Background()
+	var opts []option.ClientOption
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		log.WithFields(log.Fields{"bucket": bucket, "err": err}).Error("Error creating GCS client.")
+		return nil, err
+	}
+	return &gcsBackend{client: client, bucket: bucket}, nil
+}
+
+func (b *gcsBackend) GetWriter(name string) (io.WriteCloser, error) {
+	return b.client.Bucket(b.bucket).Object(name).NewWriter(context.Background()), nil
+}
+
+func (b *gcsBackend) GetReader(name string) (io.ReadCloser, error) {
+	return b.client.Bucket(b.bucket).Object(name).NewReader(context.Background())
+}
+
+func (b *gcsBackend) List() ([]string, error) {
+	var names []string
+	it := b.client.Bucket(b.bucket).Objects(context.Background(), nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, attrs.Name)
+	}
+	return names, nil
+}
+
+func (b *gcsBackend) Delete(name string) error {
+	return b.client.Bucket(b.bucket).Object(name).Delete(context.Background())
+}
+
+// azureBackend writes archives to an Azure Blob Storage container.
+type azureBackend struct {
+	containerURL azblob.ContainerURL
+}
+
+func newAzureBackend(account string, key string, container string) (*azureBackend, error) {
+	credential, err := azblob.NewSharedKeyCredential(account, key)
+	if err != nil {
+		log.WithFields(log.Fields{"account": account, "container": container, "err": err}).Error(
+			"Error creating Azure shared key credential.")
+		return nil, err
+	}
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+	u, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", account, container))
+	if err != nil {
+		return nil, err
+	}
+	return &azureBackend{containerURL: azblob.NewContainerURL(*u, pipeline)}, nil
+}
+
+func (b *azureBackend) GetWriter(name string) (io.WriteCloser, error) {
+	blockBlobURL := b.containerURL.NewBlockBlobURL(name)
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := azblob.UploadStreamToBlockBlob(context.Background(), pipeReader, blockBlobURL, azblob.UploadStreamToBlockBlobOptions{})
+		pipeReader.CloseWithError(err)
+	}()
+	return pipeWriter, nil
+}
+
+func (b *azureBackend) GetReader(name string) (io.ReadCloser, error) {
+	blockBlobURL := b.containerURL.NewBlockBlobURL(name)
+	resp, err := blockBlobURL.Download(context.Background(), 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body(azblob.RetryReaderOptions{}), nil
+}
+
+func (b *azureBackend) List() ([]string, error) {
+	var names []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := b.containerURL.ListBlobsFlatSegment(context.Background(), marker, azblob.ListBlobsSegmentOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range resp.Segment.BlobItems {
+			names = append(names, blob.Name)
+		}
+		marker = resp.NextMarker
+	}
+	return names, nil
+}
+
+func (b *azureBackend) Delete(name string) error {
+	_, err := b.containerURL.NewBlockBlobURL(name).Delete(context.Background(), azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	return err
+}
+
+// localBackend writes archives to a directory on the local filesystem,
+// useful for testing or for sites with their own backup-to-NFS tooling.
+type localBackend struct {
+	dir string
+}
+
+func newLocalBackend(dir string) *localBackend {
+	return &localBackend{dir: dir}
+}
+
+func (b *localBackend) GetWriter(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(filepath.Join(b.dir, name))
+}
+
+func (b *localBackend) GetReader(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(b.dir, name))
+}
+
+func (b *localBackend) List() ([]string, error) {
+	entries, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (b *localBackend) Delete(name string) error {
+	return os.Remove(filepath.Join(b.dir, name))
+}
+
+// sftpBackend writes archives to a directory on a remote host over SFTP.
+type sftpBackend struct {
+	client    *sftp.Client
+	remoteDir string
+}
+
+func newSFTPBackend(addr string, user string, keyFile string, remoteDir string, knownHostsFile string) (*sftpBackend, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		log.WithFields(log.Fields{"keyFile": keyFile, "err": err}).Error("Error reading SFTP private key.")
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := sshHostKeyCallback(knownHostsFile)
+	if err != nil {
+		log.WithFields(log.Fields{"knownHostsFile": knownHostsFile, "err": err}).Error("Error setting up SSH host-key verification.")
+		return nil, err
+	}
+	sshConfig := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	}
+	sshClient, err := ssh.Dial("tcp", addr, sshConfig)
+	if err != nil {
+		log.WithFields(log.Fields{"addr": addr, "err": err}).Error("Error connecting to SFTP host.")
+		return nil, err
+	}
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpBackend{client: client, remoteDir: remoteDir}, nil
+}
+
+func (b *sftpBackend) GetWriter(name string) (io.WriteCloser, error) {
+	if err := b.client.MkdirAll(b.remoteDir); err != nil {
+		return nil, err
+	}
+	return b.client.Create(filepath.Join(b.remoteDir, name))
+}
+
+func (b *sftpBackend) GetReader(name string) (io.ReadCloser, error) {
+	return b.client.Open(filepath.Join(b.remoteDir, name))
+}
+
+func (b *sftpBackend) List() ([]string, error) {
+	entries, err := b.client.ReadDir(b.remoteDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (b *sftpBackend) Delete(name string) error {
+	return b.client.Remove(filepath.Join(b.remoteDir, name))
+}